@@ -2,16 +2,35 @@ package plugin
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sync"
+	"time"
 
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/hashicorp/go-hclog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 )
 
+// multiplexIDMetadataKey is the metadata header a multiplexing-aware host
+// uses to tell GRPCServer which logical plugin instance a request is for.
+const multiplexIDMetadataKey = "plugin-multiplex-id"
+
+// defaultShutdownTimeout bounds how long Serve waits for GracefulStop to
+// drain in-flight RPCs before falling back to an immediate Stop.
+const defaultShutdownTimeout = 5 * time.Second
+
 // DefaultGRPCServer can be used with the "GRPCServer" field for Server
 // as a default factory method to create a gRPC server with no extra options.
 func DefaultGRPCServer(opts []grpc.ServerOption) *grpc.Server {
@@ -33,6 +52,11 @@ type GRPCServer struct {
 
 	// TLS should be the TLS configuration if available. If this is nil,
 	// the connection will not have transport security.
+	//
+	// For mutual TLS, set ClientAuth to tls.RequireAndVerifyClientCert and
+	// ClientCAs to the CA pool used to verify client certificates. Both
+	// fields are honored as-is since GRPCServer passes this config directly
+	// to credentials.NewTLS.
 	TLS *tls.Config
 
 	// DoneCh is the channel that is closed when this server has exited.
@@ -43,24 +67,170 @@ type GRPCServer struct {
 	Stdout io.Reader
 	Stderr io.Reader
 
-	config GRPCServerConfig
-	server *grpc.Server
+	// Logger is used to surface errors that happen after Serve has handed
+	// control to the background accept loop, such as TLS handshake
+	// failures, where there is no caller left to return an error to. If
+	// nil, hclog.Default() is used.
+	Logger hclog.Logger
+
+	// Gateway, if true, starts a grpc-gateway HTTP/JSON mux alongside the
+	// gRPC server for every registered plugin that implements
+	// GRPCGatewayPlugin, so non-Go clients can reach plugin RPCs over
+	// plain HTTP. The mux's address is published to the host process as
+	// GRPCServerConfig.GatewayAddr.
+	Gateway bool
+
+	// MultiplexingSupport, if true, lets a single plugin process back many
+	// logical host-side instances over the same connection. Every
+	// registered plugin implementing MultiplexedGRPCPlugin gets its
+	// InstanceFor called with the id carried in the incoming
+	// "plugin-multiplex-id" metadata header, and the resolved instance is
+	// made available to that plugin's handlers via MultiplexedInstance.
+	// GRPCServerConfig.MultiplexingSupported is set to true so the host
+	// can negotiate the feature.
+	MultiplexingSupport bool
+
+	// Transport selects the listener GRPCServer creates for itself in Init,
+	// instead of accepting whatever listener the host-managed TCP loopback
+	// produces. One of "tcp" (the default, handled by the caller of Serve
+	// as before), "unix", or "unixabstract". Gateway, if also enabled,
+	// dials back into whichever of these is selected; see
+	// gatewayDialTarget.
+	Transport string
+
+	// SocketPath is the filesystem path (Transport == "unix") or name
+	// (Transport == "unixabstract", which is never created on disk) of the
+	// socket to listen on. Required when Transport is "unix" or
+	// "unixabstract".
+	SocketPath string
+
+	// ShutdownTimeout bounds how long Serve waits, once DoneCh closes, for
+	// server.GracefulStop to drain in-flight RPCs before falling back to an
+	// immediate Stop. It also bounds how long Drain waits for in-flight
+	// RPCs to finish on their own. Defaults to defaultShutdownTimeout if
+	// zero.
+	ShutdownTimeout time.Duration
+
+	// UnaryInterceptors/StreamInterceptors are chained onto the gRPC server
+	// in Init via grpc.ChainUnaryInterceptor/ChainStreamInterceptor, ahead
+	// of the interceptors GRPCServer installs for MultiplexingSupport; only
+	// GRPCServer's own in-flight tracking for Drain runs before them. Use this to add cross-cutting behavior such as
+	// tracing or metrics without every plugin wiring its own; see the
+	// otelplugin subpackage for ready-made ones.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	config         GRPCServerConfig
+	server         *grpc.Server
+	healthServer   *health.Server
+	lis            net.Listener
+	gatewayLis     net.Listener
+	gatewayPlugins map[string]GRPCGatewayPlugin
+
+	multiplexPlugins  map[string]MultiplexedGRPCPlugin
+	multiplexMu       sync.Mutex
+	multiplexInstance map[multiplexInstanceKey]interface{}
+
+	// inFlight tracks RPCs currently being handled, so Drain can wait for
+	// them to finish without having to stop accepting new connections.
+	inFlight sync.WaitGroup
+}
+
+// multiplexInstanceKey identifies a cached multiplexed plugin instance by
+// the plugin that created it and the id the host addressed it with.
+type multiplexInstanceKey struct {
+	plugin string
+	id     string
+}
+
+// MultiplexedGRPCPlugin is an optional interface that a GRPCPlugin may also
+// implement to back many logical host-side instances from one process. It
+// is only used when GRPCServer.MultiplexingSupport is true.
+type MultiplexedGRPCPlugin interface {
+	// ID returns the instance id to use for requests that carry no
+	// "plugin-multiplex-id" header.
+	ID() string
+
+	// InstanceFor returns the backend instance that should serve requests
+	// for the given multiplex id, creating and caching it as needed. The
+	// returned value is whatever type this plugin's handlers expect; they
+	// retrieve it per-request via MultiplexedInstance.
+	InstanceFor(id string) (interface{}, error)
+}
+
+// multiplexContextKey is the context key under which GRPCServer's
+// interceptors stash resolved multiplexed instances, keyed by plugin name.
+type multiplexContextKey struct{}
+
+// MultiplexedInstance returns the backend instance that GRPCServer resolved
+// for pluginName on this request's multiplex id, via that plugin's
+// InstanceFor. It is only populated when GRPCServer.MultiplexingSupport is
+// true; a request with no "plugin-multiplex-id" header gets the instance for
+// the id that plugin's ID returns.
+func MultiplexedInstance(ctx context.Context, pluginName string) (interface{}, bool) {
+	instances, ok := ctx.Value(multiplexContextKey{}).(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	inst, ok := instances[pluginName]
+	return inst, ok
+}
+
+// GRPCGatewayPlugin is an optional interface that a GRPCPlugin may also
+// implement to expose its RPCs through a grpc-gateway HTTP/JSON mux. It is
+// only used when GRPCServer.Gateway is true.
+type GRPCGatewayPlugin interface {
+	// RegisterGateway registers this plugin's grpc-gateway handlers onto
+	// mux. conn is a client connection dialed back into the same process's
+	// GRPCServer, to be passed to the generated RegisterXxxHandler function.
+	RegisterGateway(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error
 }
 
 // ServerProtocol impl.
 func (s *GRPCServer) Init() error {
-	// TODO(mitchellh): I don't know why this is the case currently, but
-	// I'm getting connection refused errors when trying to use TLS. Given
-	// only one project uses this we should look into it later.
-	if s.TLS != nil {
-		//return fmt.Errorf("TLS is not currently supported with gRPC plugins")
+	if s.Logger == nil {
+		s.Logger = hclog.Default()
 	}
 
 	// Create our server
 	var opts []grpc.ServerOption
 	if s.TLS != nil {
-		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLS)))
+		opts = append(opts, grpc.Creds(&tlsHandshakeLogger{
+			TransportCredentials: credentials.NewTLS(s.TLS),
+			logger:               s.Logger,
+		}))
+	}
+
+	// inFlight tracking runs outermost of all, so Drain's wait covers the
+	// full duration of every RPC, including time spent in user-supplied and
+	// multiplexing interceptors below. User-supplied interceptors come
+	// next, so things like tracing and metrics see every call regardless of
+	// what else below dispatches on.
+	unaryInterceptors := append([]grpc.UnaryServerInterceptor{s.inFlightUnaryInterceptor}, s.UnaryInterceptors...)
+	streamInterceptors := append([]grpc.StreamServerInterceptor{s.inFlightStreamInterceptor}, s.StreamInterceptors...)
+
+	if s.MultiplexingSupport {
+		s.multiplexInstance = make(map[multiplexInstanceKey]interface{})
+		s.multiplexPlugins = make(map[string]MultiplexedGRPCPlugin)
+		for k, raw := range s.Plugins {
+			if mp, ok := raw.(MultiplexedGRPCPlugin); ok {
+				s.multiplexPlugins[k] = mp
+			}
+		}
+
+		unaryInterceptors = append(unaryInterceptors, s.multiplexUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, s.multiplexStreamInterceptor)
+
+		s.config.MultiplexingSupported = true
 	}
+
+	if len(unaryInterceptors) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptors...))
+	}
+	if len(streamInterceptors) > 0 {
+		opts = append(opts, grpc.ChainStreamInterceptor(streamInterceptors...))
+	}
+
 	s.server = s.Server(opts)
 
 	// Register all our plugins onto the gRPC server.
@@ -75,9 +245,256 @@ func (s *GRPCServer) Init() error {
 		}
 	}
 
+	// Register the standard health service, starting every plugin (and the
+	// server as a whole, under the empty service name) as SERVING. Drain
+	// flips all of them to NOT_SERVING before a graceful shutdown; a plugin
+	// implementing HealthReportingPlugin can flip its own entry earlier,
+	// e.g. on a fatal internal error.
+	s.healthServer = health.NewServer()
+	grpc_health_v1.RegisterHealthServer(s.server, s.healthServer)
+	s.healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	for k := range s.Plugins {
+		s.healthServer.SetServingStatus(k, grpc_health_v1.HealthCheckResponse_SERVING)
+	}
+	s.config.HealthCheckSupported = true
+
+	for k, raw := range s.Plugins {
+		if hp, ok := raw.(HealthReportingPlugin); ok {
+			hp.SetHealthReporter(&pluginHealthReporter{server: s.healthServer, name: k})
+		}
+	}
+
+	// Unix domain socket and abstract socket transports are created by
+	// GRPCServer itself, since the host can't pre-bind a filesystem path on
+	// the plugin's behalf. The plain "tcp" transport keeps using whatever
+	// listener is passed to Serve, as before.
+	switch s.Transport {
+	case "unix", "unixabstract":
+		if s.SocketPath == "" {
+			return fmt.Errorf("SocketPath is required for %q transport", s.Transport)
+		}
+
+		address := s.SocketPath
+		if s.Transport == "unixabstract" {
+			// Linux abstract sockets are addressed with a leading NUL byte
+			// and are never created on the filesystem.
+			address = "\x00" + address
+		}
+
+		lis, err := net.Listen("unix", address)
+		if err != nil {
+			return fmt.Errorf("error creating %s listener: %s", s.Transport, err)
+		}
+		s.lis = lis
+		s.config.Network = s.Transport
+		s.config.Address = s.SocketPath
+	case "", "tcp":
+		// Handled by whatever listener is passed to Serve.
+	default:
+		return fmt.Errorf("unknown transport %q", s.Transport)
+	}
+
+	// If a gateway was requested, reserve its listener now so the address
+	// can be published via Config(). The mux itself is started from Serve,
+	// once the main gRPC listener's address is known to dial back into.
+	if s.Gateway {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("error reserving gateway listener: %s", err)
+		}
+		s.gatewayLis = lis
+		s.config.GatewayAddr = lis.Addr().String()
+
+		s.gatewayPlugins = make(map[string]GRPCGatewayPlugin)
+		for k, raw := range s.Plugins {
+			if gw, ok := raw.(GRPCGatewayPlugin); ok {
+				s.gatewayPlugins[k] = gw
+			}
+		}
+	}
+
 	return nil
 }
 
+// Health returns the underlying health.Server, keyed by the name plugins
+// were registered under in Plugins (or "" for the overall server status).
+// It's for the host process itself to inspect or drive; plugin
+// implementations should instead implement HealthReportingPlugin to get a
+// reporter scoped to their own entry. Must only be called after Init.
+func (s *GRPCServer) Health() *health.Server {
+	return s.healthServer
+}
+
+// HealthReportingPlugin is an optional interface a GRPCPlugin may also
+// implement to report its own health. If implemented, SetHealthReporter is
+// called once during Init with a reporter scoped to this plugin's own
+// entry in the standard health service, e.g. so the plugin can flip itself
+// to NOT_SERVING on a fatal internal error ahead of a host-initiated Drain.
+type HealthReportingPlugin interface {
+	SetHealthReporter(r HealthReporter)
+}
+
+// HealthReporter lets a plugin implementation update its own entry in the
+// health service GRPCServer registers in Init.
+type HealthReporter interface {
+	SetServingStatus(status grpc_health_v1.HealthCheckResponse_ServingStatus)
+}
+
+// pluginHealthReporter is the HealthReporter handed to each
+// HealthReportingPlugin, scoped to that plugin's own name.
+type pluginHealthReporter struct {
+	server *health.Server
+	name   string
+}
+
+func (r *pluginHealthReporter) SetServingStatus(status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	r.server.SetServingStatus(r.name, status)
+}
+
+// Drain flips every plugin's health status, and the overall server's, to
+// NOT_SERVING, then waits up to ShutdownTimeout for RPCs already in flight
+// to finish on their own. Unlike Serve's shutdown path, Drain never stops
+// the server or closes its listener, so hosts orchestrating a rolling
+// plugin restart can call this to bleed traffic off cleanly while still
+// being able to observe the NOT_SERVING status, e.g. via the health
+// service, before terminating the process; it does not close DoneCh itself.
+// An RPC served by this GRPCServer counts as in flight for as long as its
+// handler runs, so a Drain called from inside such a handler always waits
+// the full ShutdownTimeout.
+func (s *GRPCServer) Drain() {
+	s.healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	for k := range s.Plugins {
+		s.healthServer.SetServingStatus(k, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		s.Logger.Warn("drain timed out waiting for in-flight RPCs", "timeout", timeout)
+	}
+}
+
+// inFlightUnaryInterceptor tracks in-flight unary RPCs so Drain can wait for
+// them to finish before returning.
+func (s *GRPCServer) inFlightUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s.inFlight.Add(1)
+	defer s.inFlight.Done()
+	return handler(ctx, req)
+}
+
+// inFlightStreamInterceptor is the streaming counterpart of
+// inFlightUnaryInterceptor.
+func (s *GRPCServer) inFlightStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.inFlight.Add(1)
+	defer s.inFlight.Done()
+	return handler(srv, ss)
+}
+
+// multiplexUnaryInterceptor resolves the per-id backend instance for every
+// multiplexed plugin and makes it available to handlers via ctx.
+func (s *GRPCServer) multiplexUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.withMultiplexedInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// multiplexStreamInterceptor is the streaming counterpart of
+// multiplexUnaryInterceptor.
+func (s *GRPCServer) multiplexStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.withMultiplexedInstances(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &multiplexServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// multiplexServerStream overrides Context() so downstream handlers observe
+// the multiplex-resolved context rather than the raw stream's.
+type multiplexServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *multiplexServerStream) Context() context.Context { return s.ctx }
+
+// withMultiplexedInstances reads the "plugin-multiplex-id" header off ctx,
+// if present, resolves (creating and caching as needed) the backend
+// instance each multiplexed plugin has for that id, and returns a context
+// those instances can be read back from via MultiplexedInstance.
+func (s *GRPCServer) withMultiplexedInstances(ctx context.Context) (context.Context, error) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(multiplexIDMetadataKey); len(v) > 0 {
+			id = v[0]
+		}
+	}
+
+	instances := make(map[string]interface{}, len(s.multiplexPlugins))
+	for name, p := range s.multiplexPlugins {
+		lookupID := id
+		if lookupID == "" {
+			lookupID = p.ID()
+		}
+
+		inst, err := s.multiplexedInstance(name, p, lookupID)
+		if err != nil {
+			return ctx, fmt.Errorf("error resolving multiplexed instance %q for %q: %s", lookupID, name, err)
+		}
+		instances[name] = inst
+	}
+
+	return context.WithValue(ctx, multiplexContextKey{}, instances), nil
+}
+
+// multiplexedInstance returns the cached instance for (name, id), calling
+// InstanceFor and caching the result on first use.
+func (s *GRPCServer) multiplexedInstance(name string, p MultiplexedGRPCPlugin, id string) (interface{}, error) {
+	key := multiplexInstanceKey{plugin: name, id: id}
+
+	s.multiplexMu.Lock()
+	defer s.multiplexMu.Unlock()
+
+	if inst, ok := s.multiplexInstance[key]; ok {
+		return inst, nil
+	}
+
+	inst, err := p.InstanceFor(id)
+	if err != nil {
+		return nil, err
+	}
+	s.multiplexInstance[key] = inst
+	return inst, nil
+}
+
+// closeMultiplexedInstances tears down every cached multiplexed instance
+// that implements io.Closer. It's called once Serve's DoneCh fires.
+func (s *GRPCServer) closeMultiplexedInstances() {
+	s.multiplexMu.Lock()
+	defer s.multiplexMu.Unlock()
+
+	for key, inst := range s.multiplexInstance {
+		if closer, ok := inst.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				s.Logger.Error("error closing multiplexed instance", "plugin", key.plugin, "id", key.id, "error", err)
+			}
+		}
+	}
+	s.multiplexInstance = make(map[multiplexInstanceKey]interface{})
+}
+
 // Config is the GRPCServerConfig encoded as JSON then base64.
 func (s *GRPCServer) Config() string {
 	// Create a buffer that will contain our final contents
@@ -94,12 +511,162 @@ func (s *GRPCServer) Config() string {
 	return buf.String()
 }
 
+// tlsHandshakeLogger wraps a credentials.TransportCredentials to surface
+// per-connection TLS handshake failures through s.Logger. grpc-go's
+// Server.Serve runs ServerHandshake once per accepted connection and never
+// returns a per-connection error to its own caller, so without this wrapper
+// a bad handshake (wrong client cert, TLS version mismatch, garbage on the
+// wire) is silently dropped.
+type tlsHandshakeLogger struct {
+	credentials.TransportCredentials
+	logger hclog.Logger
+}
+
+func (c *tlsHandshakeLogger) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	out, authInfo, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		c.logger.Error("tls handshake failed", "remote_addr", conn.RemoteAddr(), "error", err)
+	}
+	return out, authInfo, err
+}
+
 func (s *GRPCServer) Serve(lis net.Listener) {
-	// Start serving in a goroutine
-	go s.server.Serve(lis)
+	// A Unix/abstract socket listener is created by GRPCServer itself in
+	// Init, since its address has to be known before Config() is sent to
+	// the host; it takes priority over whatever is passed in here.
+	if s.lis != nil {
+		lis = s.lis
+	}
+
+	// Start serving in a goroutine. Serve only returns when the listener
+	// itself dies (on a clean shutdown, or a permanent Accept failure); it
+	// does not report per-connection TLS handshake errors, which
+	// tlsHandshakeLogger above logs separately as they happen.
+	go func() {
+		if err := s.server.Serve(lis); err != nil {
+			s.Logger.Error("grpc server failed to serve", "error", err)
+		}
+	}()
+
+	if s.gatewayLis != nil {
+		go s.serveGateway(s.gatewayDialTarget(lis))
+	}
 
-	// Wait until graceful completion
+	// Wait until told to shut down, then drain in-flight RPCs with
+	// GracefulStop before falling back to an immediate Stop if that takes
+	// too long.
 	<-s.DoneCh
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		s.Logger.Warn("graceful stop timed out, forcing shutdown", "timeout", timeout)
+		s.server.Stop()
+		<-stopped
+	}
+
+	if s.MultiplexingSupport {
+		s.closeMultiplexedInstances()
+	}
+}
+
+// gatewayDialTarget returns the target serveGateway should dial to reach
+// the main gRPC listener. grpc-go's default passthrough resolver already
+// handles a bare "host:port" TCP address, which is what lis.Addr().String()
+// produces for the default "tcp" transport, but a Unix domain or abstract
+// socket path needs an explicit resolver scheme or it won't be recognized
+// as a dial target at all.
+func (s *GRPCServer) gatewayDialTarget(lis net.Listener) string {
+	switch s.Transport {
+	case "unix":
+		return "unix://" + s.SocketPath
+	case "unixabstract":
+		// grpc-go's "unix-abstract:" resolver scheme takes the unprefixed
+		// socket name and maps it to Go's own "@name" abstract socket
+		// convention internally; a "unix://" URL can't express this at all
+		// since the leading NUL byte it needs is an invalid URL character.
+		return "unix-abstract:" + s.SocketPath
+	default:
+		return lis.Addr().String()
+	}
+}
+
+// gatewayDialTLSConfig builds the client-side tls.Config serveGateway uses
+// to dial back into the same process's own gRPC server. Reusing s.TLS
+// directly doesn't work here: it's a server-side config, so it has no
+// ServerName and InsecureSkipVerify is false, which makes crypto/tls
+// refuse to even attempt the handshake. Since this connection never leaves
+// the host, we deliberately trust exactly the certificate(s) the server
+// itself presents rather than verifying against some externally supplied
+// CA or host name.
+func (s *GRPCServer) gatewayDialTLSConfig(target string) *tls.Config {
+	cfg := &tls.Config{}
+
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		cfg.ServerName = host
+	}
+
+	pool := x509.NewCertPool()
+	for _, chain := range s.TLS.Certificates {
+		if len(chain.Certificate) == 0 {
+			continue
+		}
+		if leaf, err := x509.ParseCertificate(chain.Certificate[0]); err == nil {
+			pool.AddCert(leaf)
+		}
+	}
+	cfg.RootCAs = pool
+
+	// If the server requires a client certificate, present the same
+	// certificate(s) it serves with. RootCAs above already means this dial
+	// only trusts a server presenting one of these certificates, so
+	// reusing them as the client identity doesn't widen what's trusted.
+	switch s.TLS.ClientAuth {
+	case tls.RequireAnyClientCert, tls.RequireAndVerifyClientCert, tls.VerifyClientCertIfGiven:
+		cfg.Certificates = s.TLS.Certificates
+	}
+
+	return cfg
+}
+
+// serveGateway dials back into the gRPC server at grpcAddr and starts the
+// grpc-gateway HTTP/JSON mux on s.gatewayLis.
+func (s *GRPCServer) serveGateway(grpcAddr string) {
+	ctx := context.Background()
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if s.TLS != nil {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(s.gatewayDialTLSConfig(grpcAddr)))}
+	}
+
+	conn, err := grpc.DialContext(ctx, grpcAddr, dialOpts...)
+	if err != nil {
+		s.Logger.Error("error dialing grpc server for gateway", "error", err)
+		return
+	}
+
+	mux := runtime.NewServeMux()
+	for k, gw := range s.gatewayPlugins {
+		if err := gw.RegisterGateway(ctx, mux, conn); err != nil {
+			s.Logger.Error("error registering gateway", "plugin", k, "error", err)
+			return
+		}
+	}
+
+	if err := http.Serve(s.gatewayLis, mux); err != nil {
+		s.Logger.Error("gateway server failed to serve", "error", err)
+	}
 }
 
 // GRPCServerConfig is the extra configuration passed along for consumers
@@ -107,4 +674,28 @@ func (s *GRPCServer) Serve(lis net.Listener) {
 type GRPCServerConfig struct {
 	StdoutAddr string `json:"stdout_addr"`
 	StderrAddr string `json:"stderr_addr"`
+
+	// GatewayAddr is the address of the grpc-gateway HTTP/JSON mux, set
+	// only when GRPCServer.Gateway is true.
+	GatewayAddr string `json:"gateway_addr,omitempty"`
+
+	// MultiplexingSupported indicates the host may address requests to a
+	// specific plugin instance via the "plugin-multiplex-id" metadata
+	// header. Set only when GRPCServer.MultiplexingSupport is true.
+	MultiplexingSupported bool `json:"multiplexing_supported,omitempty"`
+
+	// Network and Address describe the listener the host should dial to
+	// reach the plugin's gRPC server, when GRPCServer created it itself
+	// (GRPCServer.Transport is "unix" or "unixabstract"). Network is
+	// "unix" or "unixabstract" and Address is the socket path; both are
+	// empty for the default "tcp" transport, where the host already knows
+	// the address from its own listener.
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	// HealthCheckSupported indicates a standard grpc.health.v1 Health
+	// service is registered on this same connection, so hosts can call
+	// Check/Watch to probe plugin readiness before dispatching work, and
+	// to detect when Drain has taken a plugin out of service.
+	HealthCheckSupported bool `json:"health_check_supported,omitempty"`
 }
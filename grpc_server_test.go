@@ -0,0 +1,462 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// noopGRPCPlugin is a GRPCPlugin that registers nothing of its own. It
+// exists purely so these tests can exercise GRPCServer.Init/Serve without
+// depending on any real plugin's RPCs; the standard health service GRPCServer
+// itself registers is enough to prove a TLS connection made it through.
+type noopGRPCPlugin struct{}
+
+func (noopGRPCPlugin) Server(*MuxBroker) (interface{}, error)              { return nil, nil }
+func (noopGRPCPlugin) Client(*MuxBroker, *rpc.Client) (interface{}, error) { return nil, nil }
+func (noopGRPCPlugin) GRPCServer(*grpc.Server) error                       { return nil }
+
+// gatewayHealthPlugin is a GRPCGatewayPlugin that proxies GET /healthz to
+// the standard health service over the gateway's own dialed-back
+// connection. It exists so gateway tests can exercise GRPCServer.Gateway
+// without depending on any generated protobuf gateway stubs.
+type gatewayHealthPlugin struct{ noopGRPCPlugin }
+
+func (gatewayHealthPlugin) RegisterGateway(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	client := grpc_health_v1.NewHealthClient(conn)
+	return mux.HandlePath(http.MethodGet, "/healthz", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		resp, err := client.Check(r.Context(), &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// getGatewayHealthz polls addr's /healthz endpoint until it responds or
+// timeout elapses, since Serve starts serveGateway in its own goroutine and
+// the mux may not be registered and serving yet.
+func getGatewayHealthz(addr string, timeout time.Duration) (*grpc_health_v1.HealthCheckResponse, error) {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		resp, err := http.Get("http://" + addr + "/healthz")
+		if err != nil {
+			lastErr = err
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		var out grpc_health_v1.HealthCheckResponse
+		decErr := json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if decErr != nil {
+			lastErr = decErr
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		return &out, nil
+	}
+	return nil, lastErr
+}
+
+// generateTestCA creates a throwaway, in-memory self-signed CA for use by
+// the TLS tests below.
+func generateTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating CA key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-plugin test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating CA cert: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing CA cert: %s", err)
+	}
+
+	return cert, key
+}
+
+// generateTestLeaf issues a certificate for cn, valid for 127.0.0.1, signed
+// by the given CA.
+func generateTestLeaf(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string, serial int64) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating leaf key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating leaf cert: %s", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// dialHealth dials addr with the given client TLS config and calls the
+// standard health service's Check RPC, returning whatever error either step
+// produced.
+func dialHealth(clientTLS *tls.Config, addr string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Status, nil
+}
+
+// dialHealthInsecure is dialHealth without transport security, for tests
+// against a GRPCServer with no TLS configured.
+func dialHealthInsecure(addr string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Status, nil
+}
+
+// TestGRPCServer_TLS spins up a GRPCServer over a real TCP listener and
+// verifies both server-auth-only TLS and mutual TLS work end-to-end.
+func TestGRPCServer_TLS(t *testing.T) {
+	ca, caKey := generateTestCA(t)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	serverCert := generateTestLeaf(t, ca, caKey, "127.0.0.1", 2)
+	clientCert := generateTestLeaf(t, ca, caKey, "test-client", 3)
+
+	t.Run("server auth only", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %s", err)
+		}
+
+		s := &GRPCServer{
+			Plugins: map[string]Plugin{"test": noopGRPCPlugin{}},
+			Server:  DefaultGRPCServer,
+			TLS:     &tls.Config{Certificates: []tls.Certificate{serverCert}},
+			DoneCh:  make(chan struct{}),
+		}
+		if err := s.Init(); err != nil {
+			t.Fatalf("error initializing server: %s", err)
+		}
+		go s.Serve(lis)
+		defer close(s.DoneCh)
+
+		status, err := dialHealth(&tls.Config{RootCAs: caPool, ServerName: "127.0.0.1"}, lis.Addr().String())
+		if err != nil {
+			t.Fatalf("error checking health over TLS: %s", err)
+		}
+		if status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("expected SERVING, got %s", status)
+		}
+	})
+
+	t.Run("handshake error is logged", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %s", err)
+		}
+
+		var logBuf bytes.Buffer
+		s := &GRPCServer{
+			Plugins: map[string]Plugin{"test": noopGRPCPlugin{}},
+			Server:  DefaultGRPCServer,
+			TLS:     &tls.Config{Certificates: []tls.Certificate{serverCert}},
+			Logger:  hclog.New(&hclog.LoggerOptions{Output: &logBuf, Level: hclog.Error}),
+			DoneCh:  make(chan struct{}),
+		}
+		if err := s.Init(); err != nil {
+			t.Fatalf("error initializing server: %s", err)
+		}
+		go s.Serve(lis)
+		defer close(s.DoneCh)
+
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatalf("error dialing: %s", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("not a tls client hello")); err != nil {
+			t.Fatalf("error writing garbage handshake: %s", err)
+		}
+		// The server closes the connection once the bogus handshake fails;
+		// reading until that happens bounds how long we wait for it below.
+		_, _ = conn.Read(make([]byte, 1))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if strings.Contains(logBuf.String(), "tls handshake failed") {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected a tls handshake failure to be logged, got: %q", logBuf.String())
+	})
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %s", err)
+		}
+
+		s := &GRPCServer{
+			Plugins: map[string]Plugin{"test": noopGRPCPlugin{}},
+			Server:  DefaultGRPCServer,
+			TLS: &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientCAs:    caPool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			},
+			DoneCh: make(chan struct{}),
+		}
+		if err := s.Init(); err != nil {
+			t.Fatalf("error initializing server: %s", err)
+		}
+		go s.Serve(lis)
+		defer close(s.DoneCh)
+
+		// No client certificate: the server should reject the handshake.
+		if _, err := dialHealth(&tls.Config{RootCAs: caPool, ServerName: "127.0.0.1"}, lis.Addr().String()); err == nil {
+			t.Fatal("expected dialing without a client certificate to fail")
+		}
+
+		// A certificate signed by the same CA should be accepted.
+		status, err := dialHealth(&tls.Config{
+			RootCAs:      caPool,
+			ServerName:   "127.0.0.1",
+			Certificates: []tls.Certificate{clientCert},
+		}, lis.Addr().String())
+		if err != nil {
+			t.Fatalf("error checking health over mTLS: %s", err)
+		}
+		if status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("expected SERVING, got %s", status)
+		}
+	})
+}
+
+// TestGRPCServer_GatewayWithMutualTLS verifies that enabling Gateway
+// alongside mutual TLS doesn't leave the gateway's own loopback dial unable
+// to complete the server's mTLS handshake.
+func TestGRPCServer_GatewayWithMutualTLS(t *testing.T) {
+	ca, caKey := generateTestCA(t)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	serverCert := generateTestLeaf(t, ca, caKey, "127.0.0.1", 4)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	s := &GRPCServer{
+		Plugins: map[string]Plugin{"test": gatewayHealthPlugin{}},
+		Server:  DefaultGRPCServer,
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    caPool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+		Gateway: true,
+		DoneCh:  make(chan struct{}),
+	}
+	if err := s.Init(); err != nil {
+		t.Fatalf("error initializing server: %s", err)
+	}
+	go s.Serve(lis)
+	defer close(s.DoneCh)
+
+	resp, err := getGatewayHealthz(s.config.GatewayAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("error calling gateway over mTLS: %s", err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING, got %s", resp.Status)
+	}
+}
+
+// TestGRPCServer_GatewayOverUnixTransports verifies the gateway can dial
+// back into GRPCServer's own listener over both the "unix" and
+// "unixabstract" transports.
+func TestGRPCServer_GatewayOverUnixTransports(t *testing.T) {
+	for _, tt := range []struct {
+		transport  string
+		socketPath string
+	}{
+		{"unix", filepath.Join(t.TempDir(), "test.sock")},
+		{"unixabstract", "go-plugin-test-gateway"},
+	} {
+		t.Run(tt.transport, func(t *testing.T) {
+			s := &GRPCServer{
+				Plugins:    map[string]Plugin{"test": gatewayHealthPlugin{}},
+				Server:     DefaultGRPCServer,
+				Transport:  tt.transport,
+				SocketPath: tt.socketPath,
+				Gateway:    true,
+				DoneCh:     make(chan struct{}),
+			}
+			if err := s.Init(); err != nil {
+				t.Fatalf("error initializing server: %s", err)
+			}
+			go s.Serve(nil)
+			defer close(s.DoneCh)
+
+			resp, err := getGatewayHealthz(s.config.GatewayAddr, 5*time.Second)
+			if err != nil {
+				t.Fatalf("error calling gateway over %s transport: %s", tt.transport, err)
+			}
+			if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+				t.Fatalf("expected SERVING, got %s", resp.Status)
+			}
+		})
+	}
+}
+
+// TestGRPCServer_Drain verifies that Drain waits for an in-flight RPC to
+// finish, bounded by ShutdownTimeout, and that the listener stays reachable
+// (for a health check, in particular) the whole time it's waiting.
+func TestGRPCServer_Drain(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	s := &GRPCServer{
+		Plugins:         map[string]Plugin{"test": noopGRPCPlugin{}},
+		Server:          DefaultGRPCServer,
+		ShutdownTimeout: 2 * time.Second,
+		DoneCh:          make(chan struct{}),
+	}
+	if err := s.Init(); err != nil {
+		t.Fatalf("error initializing server: %s", err)
+	}
+	go s.Serve(lis)
+	defer close(s.DoneCh)
+
+	dialCtx, cancelDial := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDial()
+	conn, err := grpc.DialContext(dialCtx, lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		t.Fatalf("error dialing: %s", err)
+	}
+	defer conn.Close()
+
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	defer cancelWatch()
+	stream, err := grpc_health_v1.NewHealthClient(conn).Watch(watchCtx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("error starting watch: %s", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("error receiving initial watch update: %s", err)
+	}
+
+	drainDone := make(chan struct{})
+	go func() {
+		s.Drain()
+		close(drainDone)
+	}()
+
+	select {
+	case <-drainDone:
+		t.Fatal("expected Drain to wait for the in-flight watch stream")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	status, err := dialHealthInsecure(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("error checking health while draining: %s", err)
+	}
+	if status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING while draining, got %s", status)
+	}
+
+	cancelWatch()
+
+	select {
+	case <-drainDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Drain to return once the in-flight stream finished")
+	}
+}
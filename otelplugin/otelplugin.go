@@ -0,0 +1,139 @@
+// Package otelplugin provides ready-made gRPC server interceptors that give
+// go-plugin's GRPCServer OpenTelemetry tracing and Prometheus metrics for
+// every plugin RPC, without each plugin author wiring their own telemetry.
+//
+// Typical usage:
+//
+//	server := &plugin.GRPCServer{
+//		Plugins:            pluginMap,
+//		UnaryInterceptors:  []grpc.UnaryServerInterceptor{otelplugin.UnaryServerInterceptor()},
+//		StreamInterceptors: []grpc.StreamServerInterceptor{otelplugin.StreamServerInterceptor()},
+//	}
+package otelplugin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tracerName identifies the tracer used for every span this package starts.
+const tracerName = "github.com/dgodyna/go-plugin/otelplugin"
+
+// requestDuration records plugin RPC latency, labeled by plugin, method and
+// resulting gRPC status code.
+var requestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "go_plugin_grpc_request_duration_seconds",
+		Help: "Duration of plugin gRPC requests, by plugin and method.",
+	},
+	[]string{"plugin", "method", "code"},
+)
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that starts
+// an OpenTelemetry span and records a Prometheus histogram for every unary
+// plugin RPC, continuing any trace context the host process propagated in
+// the request's metadata.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span, start, pluginName, method := startSpan(ctx, info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		finishSpan(span, pluginName, method, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span, start, pluginName, method := startSpan(ss.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		finishSpan(span, pluginName, method, start, err)
+		return err
+	}
+}
+
+// serverStream overrides Context() so handlers observe the span-carrying
+// context rather than the raw stream's.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// startSpan extracts any trace context the host propagated in the incoming
+// metadata, starts a span for fullMethod, and splits fullMethod into the
+// plugin (gRPC service) and method names used to label the span and metric.
+func startSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span, time.Time, string, string) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(headerMap(md)))
+	}
+
+	pluginName, method := splitMethod(fullMethod)
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, fullMethod,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("plugin.name", pluginName),
+			attribute.String("rpc.method", method),
+		),
+	)
+
+	return ctx, span, time.Now(), pluginName, method
+}
+
+// finishSpan records the outcome of a call onto span and requestDuration.
+func finishSpan(span trace.Span, pluginName, method string, start time.Time, err error) {
+	code := status.Code(err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", code.String()))
+
+	requestDuration.WithLabelValues(pluginName, method, code.String()).Observe(time.Since(start).Seconds())
+}
+
+// splitMethod splits a gRPC full method ("/plugin.Service/Method") into the
+// plugin/service name and the bare method name.
+func splitMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	parts := strings.SplitN(fullMethod, "/", 2)
+	if len(parts) != 2 {
+		return fullMethod, ""
+	}
+	return parts[0], parts[1]
+}
+
+// headerMap adapts grpc metadata.MD to the map[string]string shape that
+// propagation.MapCarrier expects, taking each header's first value.
+func headerMap(md metadata.MD) map[string]string {
+	m := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) > 0 {
+			m[k] = v[0]
+		}
+	}
+	return m
+}